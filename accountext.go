@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/url"
+
+	"github.com/1354092549/wsrpc"
+	ubot "github.com/UBotPlatform/UBot.Common.Go"
+)
+
+// ExtAccountEventEmitter holds the notifications which are not modeled by UBot.Common.Go yet.
+// They are sent over the same connection as the ones of ubot.AccountEventEmitter.
+type ExtAccountEventEmitter struct {
+	OnReceiveSelfMessage func(msgType ubot.MsgType, source string, target string, message string, info ubot.MsgInfo) error
+	OnMessageRecalled    func(msgType ubot.MsgType, source string, sender string, operator string, id string) error
+	OnMemberRoleChanged  func(source string, target string, role string) error
+	OnMemberMuted        func(source string, operator string, target string, duration int) error
+	OnMemberRemoved      func(source string, sender string, operator string) error
+	OnFriendAdded        func(user string) error
+	OnFriendDeleted      func(user string) error
+	OnAccountState       func(state string, reason string) error
+	OnGroupFileUpload    func(source string, name string, state string, detail string) error
+}
+
+func (a *ExtAccountEventEmitter) Get(rpcConn *wsrpc.WebsocketRPCConn) {
+	rpcConn.MakeNotify("on_receive_self_message", &a.OnReceiveSelfMessage, nil)
+	rpcConn.MakeNotify("on_message_recalled", &a.OnMessageRecalled, nil)
+	rpcConn.MakeNotify("on_member_role_changed", &a.OnMemberRoleChanged, nil)
+	rpcConn.MakeNotify("on_member_muted", &a.OnMemberMuted, nil)
+	rpcConn.MakeNotify("on_member_removed", &a.OnMemberRemoved, nil)
+	rpcConn.MakeNotify("on_friend_added", &a.OnFriendAdded, nil)
+	rpcConn.MakeNotify("on_friend_deleted", &a.OnFriendDeleted, nil)
+	rpcConn.MakeNotify("on_account_state", &a.OnAccountState, nil)
+	rpcConn.MakeNotify("on_group_file_upload", &a.OnGroupFileUpload, nil)
+}
+
+// ExtAccount holds the calls which are not modeled by UBot.Common.Go yet.
+type ExtAccount struct {
+	SendChatMessageEx   func(msgType ubot.MsgType, source string, target string, message string) ([]string, error)
+	RecallMessage       func(id string) error
+	SetMemberCard       func(source string, target string, card string) error
+	SetMemberAdmin      func(source string, target string, admin bool) error
+	SetMemberTitle      func(source string, target string, title string) error
+	LeaveGroup          func(source string) error
+	PostAnnouncement    func(source string, title string, text string, pinned bool, showToNewMembers bool) error
+	GetAnnouncementList func(source string) ([]Announcement, error)
+	GetFriendList       func() ([]Friend, error)
+	UploadGroupFile     func(source string, name string, dataType string, content string) error
+	InviteMembers       func(source string, targets []string) error
+}
+
+func (a *ExtAccount) Register(rpc *wsrpc.WebsocketRPC) {
+	rpc.Register("send_chat_message_ex",
+		a.SendChatMessageEx,
+		[]string{"type", "source", "target", "message"},
+		nil)
+	rpc.Register("recall_message",
+		a.RecallMessage,
+		[]string{"id"},
+		nil)
+	rpc.Register("set_member_card",
+		a.SetMemberCard,
+		[]string{"source", "target", "card"},
+		nil)
+	rpc.Register("set_member_admin",
+		a.SetMemberAdmin,
+		[]string{"source", "target", "admin"},
+		nil)
+	rpc.Register("set_member_title",
+		a.SetMemberTitle,
+		[]string{"source", "target", "title"},
+		nil)
+	rpc.Register("leave_group",
+		a.LeaveGroup,
+		[]string{"source"},
+		nil)
+	rpc.Register("post_announcement",
+		a.PostAnnouncement,
+		[]string{"source", "title", "text", "pinned", "show_to_new_members"},
+		nil)
+	rpc.Register("get_announcement_list",
+		a.GetAnnouncementList,
+		[]string{"source"},
+		nil)
+	rpc.Register("get_friend_list",
+		a.GetFriendList,
+		nil,
+		nil)
+	rpc.Register("upload_group_file",
+		a.UploadGroupFile,
+		[]string{"source", "name", "type", "content"},
+		nil)
+	rpc.Register("invite_members",
+		a.InviteMembers,
+		[]string{"source", "targets"},
+		nil)
+}
+
+// hostAccount works like ubot.HostAccount, but also makes the extended calls and notifications available
+func hostAccount(id string, creater func(*ubot.AccountEventEmitter, *ExtAccountEventEmitter) (*ubot.Account, *ExtAccount)) error {
+	return ubot.HostClient(func(managerUrl *url.URL, manager *ubot.Manager) (string, error) {
+		token, err := manager.RegisterAccount(id)
+		if err != nil {
+			return "", err
+		}
+		urlObj := *managerUrl
+		urlObj.Path = "/api/account"
+		query := url.Values{}
+		query.Set("id", id)
+		query.Set("token", token)
+		urlObj.RawQuery = query.Encode()
+		return urlObj.String(), nil
+	}, func(rpc *wsrpc.WebsocketRPC, rpcConn *wsrpc.WebsocketRPCConn) error {
+		remoteObj := new(ubot.AccountEventEmitter)
+		remoteObj.Get(rpcConn)
+		remoteExtObj := new(ExtAccountEventEmitter)
+		remoteExtObj.Get(rpcConn)
+		localObj, localExtObj := creater(remoteObj, remoteExtObj)
+		localObj.Register(rpc)
+		localExtObj.Register(rpc)
+		return nil
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/UBotPlatform/UBot.Account.OPQAgent/opq"
+)
+
+type AccountState int32
+
+const (
+	AccountOnline AccountState = iota
+	AccountOffline
+	AccountKickedOffline // another login took over, needs to be logged in again manually
+)
+
+func (s AccountState) String() string {
+	switch s {
+	case AccountOnline:
+		return "online"
+	case AccountOffline:
+		return "offline"
+	case AccountKickedOffline:
+		return "kicked_offline"
+	default:
+		return fmt.Sprintf("unknown(%d)", int32(s))
+	}
+}
+
+var errAccountOffline = errors.New("account offline")
+
+var accountState int32 = int32(AccountOnline)
+
+func getAccountState() AccountState {
+	return AccountState(atomic.LoadInt32(&accountState))
+}
+
+// setAccountState returns false if the state is not changed
+func setAccountState(state AccountState, reason string) bool {
+	old := AccountState(atomic.SwapInt32(&accountState, int32(state)))
+	if old == state {
+		return false
+	}
+	fmt.Printf("Account state changed from %s to %s: %s\n", old, state, reason)
+	return true
+}
+
+func checkAccountOnline() error {
+	state := getAccountState()
+	if state != AccountOnline {
+		return fmt.Errorf("%w (%s)", errAccountOffline, state)
+	}
+	return nil
+}
+
+// how often the account is checked while it is not online, in case the online event is missed
+// (e.g. OPQ restarted or the account logged in again while the socket was down)
+const accountProbeInterval = 30 * time.Second
+
+const accountProbeReason = "the account responds again"
+
+// probeAccountState lets a call through to OPQ while the account is not online,
+// and marks it online if the call succeeds. It returns false if the state is not changed.
+func probeAccountState() bool {
+	if getAccountState() == AccountOnline {
+		return false
+	}
+	data := make(map[string]interface{})
+	data["UserID"] = botQQ
+	var response opq.UserInfoResponse
+	err := postWebApi(luaApiPath("GetUserInfo", 10), data, &response)
+	if err != nil || response.Code != 0 || response.Data.Nickname == "" {
+		return false
+	}
+	return setAccountState(AccountOnline, accountProbeReason)
+}
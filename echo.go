@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/UBotPlatform/UBot.Account.OPQAgent/opq"
+	ubot "github.com/UBotPlatform/UBot.Common.Go"
+)
+
+// OPQ echoes what the bot sends as messages from the bot itself.
+// A packet sent is matched with its echo by content, which tells echoes from
+// the messages sent by someone else using the same account (e.g. on the phone),
+// and gives the ID of the message sent. Echoes are only expected when someone needs them.
+
+const echoTimeout = 30 * time.Second
+
+type echoKind int
+
+const (
+	textEcho echoKind = iota
+	pictureEcho
+	forwardEcho
+)
+
+// echoFingerprint describes a sent packet by what its echo is expected to contain
+type echoFingerprint struct {
+	kind       echoKind
+	segments   []string // the normalized text around at markups, in order
+	exact      bool     // no at markups, which OPQ echoes as nicknames
+	forwardBuf string
+}
+
+// echoMessage describes a message echoed by OPQ
+type echoMessage struct {
+	kind       echoKind
+	text       string // normalized
+	at         bool
+	forwardBuf string
+}
+
+type pendingEcho struct {
+	fingerprint echoFingerprint
+	id          chan string
+}
+
+var pendingEchoes = make(map[string][]*pendingEcho)
+var pendingEchoesLock sync.Mutex
+
+var echoMarkupMatcher = regexp.MustCompile(`\[(?:ATALL\(\)|ATUSER\(\d+\))\]`)
+
+// normalizeEchoText drops whitespace (which OPQ may trim) and the zero-width spaces put by neutralizeMarkup
+func normalizeEchoText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '\u200b' {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+func packetFingerprint(packet *MsgPacket) echoFingerprint {
+	var f echoFingerprint
+	switch packet.Kind {
+	case ForwardPacket:
+		f.kind = forwardEcho
+		f.forwardBuf = packet.ForwardBuf
+		return f
+	case PicturePacket:
+		f.kind = pictureEcho
+	default:
+		f.kind = textEcho
+	}
+	content := strings.TrimPrefix(packet.Content, picFlag)
+	f.exact = !echoMarkupMatcher.MatchString(content)
+	for _, segment := range echoMarkupMatcher.Split(content, -1) {
+		segment = normalizeEchoText(segment)
+		if segment != "" {
+			f.segments = append(f.segments, segment)
+		}
+	}
+	return f
+}
+
+func parseEchoMessage(opqMsgType string, opqMsg string) (echoMessage, bool) {
+	var m echoMessage
+	switch opqMsgType {
+	case "TextMsg":
+		m.kind = textEcho
+		m.text = normalizeEchoText(opqMsg)
+	case "AtMsg":
+		var parsed opq.AtMsg
+		if json.Unmarshal([]byte(opqMsg), &parsed) != nil {
+			return m, false
+		}
+		m.kind = textEcho
+		m.text = normalizeEchoText(parsed.Content)
+		m.at = len(parsed.UserID) != 0
+	case "PicMsg":
+		var parsed opq.PicMsg
+		if json.Unmarshal([]byte(opqMsg), &parsed) != nil {
+			return m, false
+		}
+		m.kind = pictureEcho
+		m.text = normalizeEchoText(parsed.Content)
+		m.at = len(parsed.UserID) != 0
+	case "BigFaceMsg":
+		var parsed opq.BigFaceMsg
+		if json.Unmarshal([]byte(opqMsg), &parsed) != nil {
+			return m, false
+		}
+		m.kind = forwardEcho
+		m.forwardBuf = parsed.ForwardBuf
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+func (f *echoFingerprint) matches(m *echoMessage) bool {
+	if f.kind != m.kind {
+		return false
+	}
+	if f.kind == forwardEcho {
+		return f.forwardBuf == m.forwardBuf
+	}
+	if f.exact == m.at {
+		return false
+	}
+	if f.exact {
+		return strings.Join(f.segments, "") == m.text
+	}
+	text := m.text
+	for _, segment := range f.segments {
+		p := strings.Index(text, segment)
+		if p == -1 {
+			return false
+		}
+		text = text[p+len(segment):]
+	}
+	return true
+}
+
+func echoChatKey(msgType ubot.MsgType, peer uint64) string {
+	switch msgType {
+	case ubot.GroupMsg:
+		return fmt.Sprintf("group%d", peer)
+	default:
+		return fmt.Sprintf("friend%d", peer)
+	}
+}
+
+// expectEcho registers the echo of the packet, which is dropped after echoTimeout if it never comes
+func expectEcho(chat string, packet *MsgPacket) *pendingEcho {
+	echo := &pendingEcho{fingerprint: packetFingerprint(packet), id: make(chan string, 1)}
+	pendingEchoesLock.Lock()
+	pendingEchoes[chat] = append(pendingEchoes[chat], echo)
+	pendingEchoesLock.Unlock()
+	time.AfterFunc(echoTimeout, func() {
+		cancelEcho(chat, echo)
+	})
+	return echo
+}
+
+// waitEcho returns the ID of the echoed message, or an empty string if there is no echo before the deadline
+func waitEcho(chat string, echo *pendingEcho, deadline time.Time) string {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case id := <-echo.id:
+		return id
+	case <-timer.C:
+		cancelEcho(chat, echo)
+		select {
+		case id := <-echo.id: // resolved right before the deadline
+			return id
+		default:
+			return ""
+		}
+	}
+}
+
+func cancelEcho(chat string, echo *pendingEcho) {
+	pendingEchoesLock.Lock()
+	defer pendingEchoesLock.Unlock()
+	echoes := pendingEchoes[chat]
+	for i, e := range echoes {
+		if e == echo {
+			pendingEchoes[chat] = append(echoes[:i:i], echoes[i+1:]...)
+			break
+		}
+	}
+	if len(pendingEchoes[chat]) == 0 {
+		delete(pendingEchoes, chat)
+	}
+}
+
+// resolveEcho consumes the oldest pending echo of the chat matching the message,
+// it returns false if the message is not an echo
+func resolveEcho(chat string, opqMsgType string, opqMsg string, id string) bool {
+	m, ok := parseEchoMessage(opqMsgType, opqMsg)
+	if !ok {
+		return false
+	}
+	pendingEchoesLock.Lock()
+	defer pendingEchoesLock.Unlock()
+	echoes := pendingEchoes[chat]
+	for i, echo := range echoes {
+		if echo.fingerprint.matches(&m) {
+			echo.id <- id
+			pendingEchoes[chat] = append(echoes[:i:i], echoes[i+1:]...)
+			if len(pendingEchoes[chat]) == 0 {
+				delete(pendingEchoes, chat)
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveEcho(t *testing.T) {
+	chat := "group1"
+	first := expectEcho(chat, &MsgPacket{Content: "first"})
+	second := expectEcho(chat, &MsgPacket{Content: "[ATUSER(2)] second"})
+	picture := expectEcho(chat, &MsgPacket{Kind: PicturePacket, Content: "[PICFLAG]caption"})
+	forward := expectEcho(chat, &MsgPacket{Kind: ForwardPacket, ForwardField: 12, ForwardBuf: "buf"})
+	defer func() {
+		for _, echo := range []*pendingEcho{first, second, picture, forward} {
+			cancelEcho(chat, echo)
+		}
+	}()
+
+	cases := []struct {
+		name    string
+		msgType string
+		content string
+		echo    bool
+	}{
+		{"sent from the phone", "TextMsg", "hello", false},
+		{"same text as an at packet", "TextMsg", "second", false},
+		{"at packet", "AtMsg", `{"Content":"@someone second","UserID":[2]}`, true},
+		{"text packet", "TextMsg", "first", true},
+		{"text packet echoed once", "TextMsg", "first", false},
+		{"picture packet", "PicMsg", `{"Content":"caption","GroupPic":[{"Url":"http://example.com/a.png"}]}`, true},
+		{"other big face", "BigFaceMsg", `{"ForwordBuf":"other","ForwordField":12}`, false},
+		{"big face packet", "BigFaceMsg", `{"ForwordBuf":"buf","ForwordField":12}`, true},
+	}
+	for i, c := range cases {
+		id := c.name
+		if got := resolveEcho(chat, c.msgType, c.content, id); got != c.echo {
+			t.Errorf("case %d (%s): resolveEcho = %v, want %v", i, c.name, got, c.echo)
+		}
+	}
+
+	deadline := time.Now()
+	for _, c := range []struct {
+		echo *pendingEcho
+		id   string
+	}{{first, "text packet"}, {second, "at packet"}, {picture, "picture packet"}, {forward, "big face packet"}} {
+		if got := waitEcho(chat, c.echo, deadline); got != c.id {
+			t.Errorf("waitEcho = %q, want %q", got, c.id)
+		}
+	}
+}
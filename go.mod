@@ -3,6 +3,7 @@ module github.com/UBotPlatform/UBot.Account.OPQAgent
 go 1.14
 
 require (
+	github.com/1354092549/wsrpc v0.3.2
 	github.com/UBotPlatform/UBot.Common.Go v0.0.0-20200905032245-d7cbc28fc41d
 	github.com/graarh/golang-socketio v0.0.0-20170510162725-2c44953b9b5f
 	github.com/patrickmn/go-cache v2.1.0+incompatible
@@ -2,11 +2,16 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -20,19 +25,83 @@ import (
 )
 
 var event *ubot.AccountEventEmitter
+var extEvent *ExtAccountEventEmitter
 var botAddr string
 var botQQStr string
 var botQQ uint64
 var userInfoCache = cache.New(10*time.Minute, 5*time.Minute)
 var groupNameCache = cache.New(10*time.Minute, 5*time.Minute)
 var memberNameCache = cache.New(10*time.Minute, 5*time.Minute)
+var memberRoleCache = cache.New(10*time.Minute, 5*time.Minute)
+var groupOwnerCache = cache.New(10*time.Minute, 5*time.Minute)
 
-func luaApiCaller(funcName string, data interface{}, response interface{}) error {
+// the names of friends, kept by getFriendList and the friend events, other users are looked up by getUserInfo
+var userNameCache = cache.New(cache.NoExpiration, 0)
+
+// how often the friend list is fetched again, which keeps the names in userNameCache up to date
+const friendListRefreshInterval = 1 * time.Hour
+
+// members being removed by the bot itself, so that their exit events can be told from voluntary leaving
+var removingMemberCache = cache.New(1*time.Minute, 1*time.Minute)
+
+// OPQ gives no operator in exit events, but the tip text of a removal mentions it
+var removedTipMatcher = regexp.MustCompile(`移出|踢出`)
+
+const (
+	memberRoleOwner  = "owner"
+	memberRoleAdmin  = "admin"
+	memberRoleMember = "member"
+)
+
+// revoke events only carry the seq and random of a message, this maps them back to the full ID.
+// Members can only recall messages within 2 minutes, older messages get IDs without the time (see getGroupMsgID).
+var groupMsgIDCache = cache.New(3*time.Minute, 1*time.Minute)
+
+// image_file entities may only refer to files inside these directories
+var allowedImageDirs []string
+
+const maxImageFileSize = 20 * 1024 * 1024
+
+// local files uploaded to groups may only be inside these directories
+var allowedUploadDirs []string
+
+// files are held in memory as base64 while being uploaded
+const maxUploadFileSize = 20 * 1024 * 1024
+
+// how long OPQ may take to upload a file, in seconds
+const uploadTimeout = 120
+
+// states of on_group_file_upload
+const (
+	uploadStarted  = "started"
+	uploadFinished = "finished"
+	uploadFailed   = "failed"
+)
+
+// how long sendChatMessageEx waits for the echoes of sent messages
+const sendEchoWaitTime = 5 * time.Second
+
+// whether messages sent by someone else using the bot account are delivered to UBot
+var forwardSelfMessages = false
+
+// text longer than this (in characters) is delivered as consecutive messages, 0 means no limit
+var maxTextLength = 3000
+
+func webApiCaller(path string, data interface{}, response interface{}) error {
+	err := checkAccountOnline()
+	if err != nil {
+		return err
+	}
+	return postWebApi(path, data, response)
+}
+
+// postWebApi calls OPQ regardless of the account state
+func postWebApi(path string, data interface{}, response interface{}) error {
 	dataBytes, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
-	url := "http://" + botAddr + "/v1/LuaApiCaller?funcname=" + funcName + "&timeout=10&qq=" + botQQStr
+	url := "http://" + botAddr + path
 	resp, err := http.Post(url, "application/json", bytes.NewBuffer(dataBytes))
 	if err != nil {
 		return err
@@ -47,6 +116,19 @@ func luaApiCaller(funcName string, data interface{}, response interface{}) error
 	return nil
 }
 
+func luaApiCaller(funcName string, data interface{}, response interface{}) error {
+	return luaApiCallerWithTimeout(funcName, 10, data, response)
+}
+
+// luaApiCallerWithTimeout works like luaApiCaller, but lets OPQ take up to timeout seconds
+func luaApiCallerWithTimeout(funcName string, timeout int, data interface{}, response interface{}) error {
+	return webApiCaller(luaApiPath(funcName, timeout), data, response)
+}
+
+func luaApiPath(funcName string, timeout int) string {
+	return fmt.Sprintf("/v1/LuaApiCaller?funcname=%s&timeout=%d&qq=%s", funcName, timeout, botQQStr)
+}
+
 func getUserInfo(uid string) (*opq.UserInfo, error) {
 	vCached, cached := userInfoCache.Get(uid)
 	if cached {
@@ -69,17 +151,42 @@ func getUserInfo(uid string) (*opq.UserInfo, error) {
 	userInfoCache.Set(uid, &response.Data, cache.DefaultExpiration)
 	return &response.Data, nil
 }
+func setGroupOwner(groupID uint64, ownerID uint64) {
+	if ownerID == 0 {
+		return
+	}
+	groupOwnerCache.Set(fmt.Sprint(groupID), ownerID, cache.DefaultExpiration)
+	memberRoleCache.Set(fmt.Sprintf("%d.%d", groupID, ownerID), memberRoleOwner, cache.DefaultExpiration)
+}
+
+// fetchGroupList gets all the groups page by page, refreshing their names and owners
+func fetchGroupList() ([]opq.GroupInfo, error) {
+	var r []opq.GroupInfo
+	nextToken := ""
+	for {
+		data := make(map[string]interface{})
+		data["NextToken"] = nextToken
+		var response opq.GroupListResponse
+		err := luaApiCaller("friendlist.GetTroopListReqV2", data, &response)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range response.GroupList {
+			groupNameCache.Set(fmt.Sprint(group.GroupID), group.GroupName, cache.DefaultExpiration)
+			setGroupOwner(group.GroupID, group.GroupOwner)
+		}
+		r = append(r, response.GroupList...)
+		if response.NextToken == "" || response.NextToken == nextToken || len(response.GroupList) == 0 {
+			return r, nil
+		}
+		nextToken = response.NextToken
+	}
+}
 func getGroupNameByList(id string) (string, error) {
-	data := make(map[string]interface{})
-	data["NextToken"] = ""
-	var response opq.GroupListResponse
-	err := luaApiCaller("friendlist.GetTroopListReqV2", data, &response)
+	_, err := fetchGroupList()
 	if err != nil {
 		return "", err
 	}
-	for _, group := range response.GroupList {
-		groupNameCache.Set(fmt.Sprint(group.GroupID), group.GroupName, cache.DefaultExpiration)
-	}
 	vCached, cached := groupNameCache.Get(id)
 	if cached {
 		return vCached.(string), nil
@@ -120,6 +227,10 @@ func getGroupName(id string) (string, error) {
 	return r, nil
 }
 func getUserName(id string) (string, error) {
+	vCached, cached := userNameCache.Get(id)
+	if cached {
+		return vCached.(string), nil
+	}
 	u, err := getUserInfo(id)
 	if err != nil {
 		return "", err
@@ -127,43 +238,31 @@ func getUserName(id string) (string, error) {
 	return u.Nickname, nil
 }
 
-type MsgPacket struct {
-	Content      string
-	PicUrl       string
-	PicBase64    string
-	ForwardField int
-	ForwardBuf   string
-}
-
-func (p *MsgPacket) IsEmpty() bool {
-	return p.Content == "" && p.PicUrl == "" && p.ForwardBuf == ""
-}
-
-func sendChatMessagePackets(msgType ubot.MsgType, source string, target string, packets []*MsgPacket) error {
+// sendChatMessagePackets returns the chat and the pending echoes of the sent packets, if expectEchoes is set
+func sendChatMessagePackets(msgType ubot.MsgType, source string, target string, packets []*MsgPacket, expectEchoes bool) (string, []*pendingEcho, error) {
 	iSource, err := strconv.ParseUint(source, 10, 64)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 	iTarget, err := strconv.ParseUint(target, 10, 64)
 	if err != nil {
-		return err
+		return "", nil, err
+	}
+	echoes := make([]*pendingEcho, 0, len(packets))
+	echoChat := echoChatKey(msgType, iTarget)
+	if msgType == ubot.GroupMsg {
+		echoChat = echoChatKey(msgType, iSource)
 	}
 	for _, packet := range packets {
 		data := make(map[string]interface{})
-		switch {
-		case packet.ForwardBuf != "":
+		switch packet.Kind {
+		case ForwardPacket:
 			data["sendMsgType"] = "ForwordMsg"
 			data["forwordBuf"] = packet.ForwardBuf
 			data["forwordField"] = packet.ForwardField
-		case packet.PicUrl != "":
+		case PicturePacket:
 			data["sendMsgType"] = "PicMsg"
 			data["picUrl"] = packet.PicUrl
-			data["picBase64Buf"] = ""
-			data["fileMd5"] = ""
-			data["flashPic"] = 0
-		case packet.PicBase64 != "":
-			data["sendMsgType"] = "PicMsg"
-			data["picUrl"] = ""
 			data["picBase64Buf"] = packet.PicBase64
 			data["fileMd5"] = ""
 			data["flashPic"] = 0
@@ -182,84 +281,138 @@ func sendChatMessagePackets(msgType ubot.MsgType, source string, target string,
 			data["sendToType"] = 1
 			data["groupid"] = iSource
 		}
+		var echo *pendingEcho
+		if expectEchoes {
+			echo = expectEcho(echoChat, packet)
+		}
 		var response opq.OPQErrorResponse
 		err := luaApiCaller("SendMsg", data, &response)
+		if err == nil && response.Ret != 0 {
+			err = response
+		}
 		if err != nil {
-			return err
+			if echo != nil {
+				cancelEcho(echoChat, echo)
+			}
+			return echoChat, echoes, err
 		}
-		if response.Ret != 0 {
-			return response
+		if echo != nil {
+			echoes = append(echoes, echo)
 		}
 	}
-	return nil
+	return echoChat, echoes, nil
 }
 
-func sendChatMessage(msgType ubot.MsgType, source string, target string, message string) error {
-	entities := ubot.ParseMsg(message)
-	packets := make([]*MsgPacket, 0, 2)
-	packet := &MsgPacket{}
-	imagePacket := func(setter func()) {
-		if !packet.IsEmpty() {
-			if packet.PicUrl != "" || packet.PicBase64 != "" {
-				packets = append(packets, packet)
-				packet = &MsgPacket{}
-				setter()
-			} else if packet.Content != "" { //由于第一个判断不成立，此时消息处于无图状态
-				packet.Content = "[PICFLAG]" + packet.Content
-				setter()
-				packets = append(packets, packet)
-				packet = &MsgPacket{}
-			} else {
-				packets = append(packets, packet)
-				packet = &MsgPacket{}
-				setter()
-			}
-		} else {
-			setter()
-		}
-	}
-	for _, entity := range entities {
-		switch entity.Type {
-		case "text":
-			packet.Content += entity.Data
-		case "face":
-			packet.Content += fmt.Sprintf("[表情%s]", entity.Data)
-		case "at":
-			if entity.Data == "all" {
-				packet.Content += "[ATALL()]"
-			} else {
-				packet.Content += fmt.Sprintf("[ATUSER(%s)]", entity.Data)
-			}
-		case "image_online":
-			imagePacket(func() {
-				packet.PicUrl = entity.Data
-			})
-		case "image_base64":
-			imagePacket(func() {
-				packet.PicBase64 = entity.Data
-			})
-		case "big_face":
-			if !packet.IsEmpty() {
-				packets = append(packets, packet)
-				packet = &MsgPacket{}
-			}
-			pComma := strings.IndexByte(entity.Data, ',')
-			if pComma == -1 {
-				return errors.New("invalid big_face entity")
-			}
-			forwardField, err := strconv.Atoi(entity.Data[:pComma])
-			if err != nil {
-				return errors.New("invalid big_face entity")
-			}
-			forwardBuf := entity.Data[pComma+1:]
-			packets = append(packets, &MsgPacket{ForwardField: forwardField, ForwardBuf: forwardBuf})
+func splitPathList(list string) []string {
+	var r []string
+	for _, path := range filepath.SplitList(list) {
+		if path == "" {
+			continue
+		}
+		path, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+		r = append(r, path)
+	}
+	return r
+}
+
+func isPathInDirs(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			continue
+		}
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// readLocalFile reads a file inside dirs as base64, kind is only used in errors
+func readLocalFile(kind string, path string, dirs []string, maxSize int64) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if !isPathInDirs(path, dirs) {
+		return "", fmt.Errorf("%s file %s is not in an allowed directory", kind, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s file %s is not a regular file", kind, path)
+	}
+	if info.Size() > maxSize {
+		return "", fmt.Errorf("%s file %s is too large (%d bytes, max %d bytes)", kind, path, info.Size(), maxSize)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(content), nil
+}
+
+func readImageFile(path string) (string, error) {
+	if len(allowedImageDirs) == 0 {
+		return "", errors.New("image_file is disabled since no image directory is allowed")
+	}
+	return readLocalFile("image", path, allowedImageDirs, maxImageFileSize)
+}
+
+func planChatMessage(message string) ([]*MsgPacket, error) {
+	packets, err := planMsgPackets(ubot.ParseMsg(message), maxTextLength)
+	if err != nil {
+		return nil, err
+	}
+	// local files are read before anything is sent, so that a bad path does not leave the message half-sent
+	for _, packet := range packets {
+		if packet.PicPath == "" {
+			continue
+		}
+		packet.PicBase64, err = readImageFile(packet.PicPath)
+		if err != nil {
+			return nil, err
 		}
 	}
-	if !packet.IsEmpty() {
-		packets = append(packets, packet)
-		packet = nil
+	return packets, nil
+}
+
+func sendChatMessage(msgType ubot.MsgType, source string, target string, message string) error {
+	packets, err := planChatMessage(message)
+	if err != nil {
+		return err
+	}
+	// echoes are only needed to keep them from being forwarded as self messages
+	_, _, err = sendChatMessagePackets(msgType, source, target, packets, forwardSelfMessages)
+	return err
+}
+
+// sendChatMessageEx works like sendChatMessage, but also returns the IDs of the messages sent,
+// one for each message OPQ actually sent (empty if the ID is not known in time)
+func sendChatMessageEx(msgType ubot.MsgType, source string, target string, message string) ([]string, error) {
+	packets, err := planChatMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	chat, echoes, err := sendChatMessagePackets(msgType, source, target, packets, true)
+	deadline := time.Now().Add(sendEchoWaitTime)
+	ids := make([]string, 0, len(echoes))
+	for _, echo := range echoes {
+		ids = append(ids, waitEcho(chat, echo, deadline))
 	}
-	return sendChatMessagePackets(msgType, source, target, packets)
+	return ids, err
 }
 
 func removeMember(source string, target string) error {
@@ -271,19 +424,26 @@ func removeMember(source string, target string) error {
 	if err != nil {
 		return err
 	}
+	err = checkManagePermission("remove_member", iSource, iTarget)
+	if err != nil {
+		return err
+	}
 	data := make(map[string]interface{})
 	data["ActionType"] = 3
 	data["GroupID"] = iSource
 	data["ActionUserID"] = iTarget
 	data["Content"] = ""
+	// the exit event may arrive before the response
+	removingMemberCache.Set(fmt.Sprintf("%d.%d", iSource, iTarget), botQQ, cache.DefaultExpiration)
 	var response opq.OPQErrorResponse
 	err = luaApiCaller("GroupMgr", data, &response)
+	if err == nil && response.Ret != 0 {
+		err = response
+	}
 	if err != nil {
+		removingMemberCache.Delete(fmt.Sprintf("%d.%d", iSource, iTarget))
 		return err
 	}
-	if response.Ret != 0 {
-		return response
-	}
 	return nil
 }
 func shutupMember(source string, target string, duration int) error {
@@ -295,6 +455,10 @@ func shutupMember(source string, target string, duration int) error {
 	if err != nil {
 		return err
 	}
+	err = checkManagePermission("shutup_member", iSource, iTarget)
+	if err != nil {
+		return err
+	}
 	data := make(map[string]interface{})
 	data["GroupID"] = iSource
 	data["ShutUpUserID"] = iTarget
@@ -314,6 +478,10 @@ func shutupAllMember(source string, shutupSwitch bool) error {
 	if err != nil {
 		return err
 	}
+	err = checkManagePermission("shutup_all_member", iSource, 0)
+	if err != nil {
+		return err
+	}
 	data := make(map[string]interface{})
 	data["GroupID"] = iSource
 	if shutupSwitch {
@@ -332,6 +500,365 @@ func shutupAllMember(source string, shutupSwitch bool) error {
 	return nil
 }
 
+var groupMsgIDMatcher = regexp.MustCompile(`^group(\d+)\.\d+\.(\d+)\.(\d+)$`)
+
+func recallMessage(id string) error {
+	match := groupMsgIDMatcher.FindStringSubmatch(id)
+	if match == nil {
+		if strings.HasPrefix(id, "friend") {
+			return errors.New("recalling private messages is not supported")
+		}
+		return errors.New("invalid message id")
+	}
+	data := make(map[string]interface{})
+	data["GroupID"], _ = strconv.ParseUint(match[1], 10, 64)
+	data["MsgSeq"], _ = strconv.ParseUint(match[2], 10, 64)
+	data["MsgRandom"], _ = strconv.ParseUint(match[3], 10, 64)
+	var response opq.OPQErrorResponse
+	err := luaApiCaller("PbMessageSvc.PbMsgWithDraw", data, &response)
+	if err != nil {
+		return err
+	}
+	if response.Ret != 0 {
+		return response
+	}
+	return nil
+}
+
+func setMemberCard(source string, target string, card string) error {
+	iSource, err := strconv.ParseUint(source, 10, 64)
+	if err != nil {
+		return err
+	}
+	iTarget, err := strconv.ParseUint(target, 10, 64)
+	if err != nil {
+		return err
+	}
+	if iTarget != botQQ {
+		err = checkManagePermission("set_member_card", iSource, iTarget)
+		if err != nil {
+			return err
+		}
+	}
+	data := make(map[string]interface{})
+	data["GroupID"] = iSource
+	data["UserID"] = iTarget
+	data["NewNick"] = card
+	var response opq.OPQErrorResponse
+	err = luaApiCaller("ModifyGroupCard", data, &response)
+	if err != nil {
+		return err
+	}
+	if response.Ret != 0 {
+		return response
+	}
+	memberKey := fmt.Sprintf("%d.%d", iSource, iTarget)
+	if card == "" {
+		memberNameCache.Delete(memberKey) // falls back to the nickname
+	} else {
+		memberNameCache.Set(memberKey, card, cache.DefaultExpiration)
+	}
+	return nil
+}
+
+// PermissionError is returned when the bot does not have the role an operation requires
+type PermissionError struct {
+	Operation    string
+	RequiredRole string
+}
+
+func (e PermissionError) Error() string {
+	return fmt.Sprintf("permission denied: %s requires the bot to be %s", e.Operation, e.RequiredRole)
+}
+
+func getGroupOwner(groupID uint64) (uint64, error) {
+	vCached, cached := groupOwnerCache.Get(fmt.Sprint(groupID))
+	if !cached {
+		_, err := fetchGroupList() // refreshes the owners
+		if err != nil {
+			return 0, err
+		}
+		vCached, cached = groupOwnerCache.Get(fmt.Sprint(groupID))
+		if !cached {
+			return 0, errors.New("cannot find the group")
+		}
+	}
+	return vCached.(uint64), nil
+}
+
+// getMemberRole only looks up the cache, which is filled by getMemberList and the admin events
+func getMemberRole(groupID uint64, memberID uint64) (string, bool) {
+	vCached, cached := memberRoleCache.Get(fmt.Sprintf("%d.%d", groupID, memberID))
+	if !cached {
+		return "", false
+	}
+	return vCached.(string), true
+}
+
+// checkManagePermission fails fast when the cached roles tell that the bot cannot manage the target,
+// target is 0 for operations on the whole group. Unknown roles are left for OPQ to check.
+func checkManagePermission(operation string, groupID uint64, target uint64) error {
+	if owner, err := getGroupOwner(groupID); err == nil && owner == botQQ {
+		return nil // the owner is told by the group list, which outranks the member roles cached
+	}
+	botRole, known := getMemberRole(groupID, botQQ)
+	if !known || botRole == memberRoleOwner {
+		return nil
+	}
+	if botRole == memberRoleMember {
+		return PermissionError{Operation: operation, RequiredRole: memberRoleAdmin}
+	}
+	if target != 0 {
+		if targetRole, _ := getMemberRole(groupID, target); targetRole == memberRoleAdmin || targetRole == memberRoleOwner {
+			return PermissionError{Operation: operation, RequiredRole: memberRoleOwner}
+		}
+	}
+	return nil
+}
+
+func setMemberAdmin(source string, target string, admin bool) error {
+	iSource, err := strconv.ParseUint(source, 10, 64)
+	if err != nil {
+		return err
+	}
+	iTarget, err := strconv.ParseUint(target, 10, 64)
+	if err != nil {
+		return err
+	}
+	owner, err := getGroupOwner(iSource)
+	if err != nil {
+		return err
+	}
+	if owner != botQQ {
+		return PermissionError{Operation: "set_member_admin", RequiredRole: memberRoleOwner}
+	}
+	data := make(map[string]interface{})
+	data["GroupID"] = iSource
+	data["UserID"] = iTarget
+	if admin {
+		data["Flag"] = 1
+	} else {
+		data["Flag"] = 0
+	}
+	var response opq.OPQErrorResponse
+	err = luaApiCaller("OidbSvc.0x55c_1", data, &response)
+	if err != nil {
+		return err
+	}
+	if response.Ret != 0 {
+		return response
+	}
+	role := memberRoleMember
+	if admin {
+		role = memberRoleAdmin
+	}
+	memberRoleCache.Set(fmt.Sprintf("%d.%d", iSource, iTarget), role, cache.DefaultExpiration)
+	return nil
+}
+
+func setMemberTitle(source string, target string, title string) error {
+	iSource, err := strconv.ParseUint(source, 10, 64)
+	if err != nil {
+		return err
+	}
+	iTarget, err := strconv.ParseUint(target, 10, 64)
+	if err != nil {
+		return err
+	}
+	owner, err := getGroupOwner(iSource)
+	if err != nil {
+		return err
+	}
+	if owner != botQQ {
+		return PermissionError{Operation: "set_member_title", RequiredRole: memberRoleOwner}
+	}
+	data := make(map[string]interface{})
+	data["GroupID"] = iSource
+	data["UserID"] = iTarget
+	data["NewTitle"] = title
+	var response opq.OPQErrorResponse
+	err = luaApiCaller("OidbSvc.0x8fc_2", data, &response)
+	if err != nil {
+		return err
+	}
+	if response.Ret != 0 {
+		return response
+	}
+	return nil
+}
+
+func forgetGroup(groupID uint64) {
+	groupIDStr := fmt.Sprint(groupID)
+	groupNameCache.Delete(groupIDStr)
+	groupOwnerCache.Delete(groupIDStr)
+	prefix := groupIDStr + "."
+	for _, c := range []*cache.Cache{memberNameCache, memberRoleCache} {
+		for key := range c.Items() {
+			if strings.HasPrefix(key, prefix) {
+				c.Delete(key)
+			}
+		}
+	}
+}
+
+func leaveGroup(source string) error {
+	iSource, err := strconv.ParseUint(source, 10, 64)
+	if err != nil {
+		return err
+	}
+	data := make(map[string]interface{})
+	data["ActionType"] = 2
+	data["GroupID"] = iSource
+	data["ActionUserID"] = 0
+	data["Content"] = ""
+	var response opq.OPQErrorResponse
+	err = luaApiCaller("GroupMgr", data, &response)
+	if err != nil {
+		return err
+	}
+	if response.Ret != 0 {
+		return response
+	}
+	forgetGroup(iSource)
+	return nil
+}
+
+func inviteMember(groupID uint64, userID uint64) error {
+	data := make(map[string]interface{})
+	data["ActionType"] = 8
+	data["GroupID"] = groupID
+	data["ActionUserID"] = userID
+	data["Content"] = ""
+	var response opq.OPQErrorResponse
+	err := luaApiCaller("GroupMgr", data, &response)
+	if err != nil {
+		return err
+	}
+	if response.Ret != 0 {
+		return response
+	}
+	return nil
+}
+
+// inviteMembers invites the friends of the bot into the group, a failed one does not stop the others
+func inviteMembers(source string, targets []string) error {
+	iSource, err := strconv.ParseUint(source, 10, 64)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, target := range targets {
+		iTarget, err := strconv.ParseUint(target, 10, 64)
+		if err == nil {
+			err = inviteMember(iSource, iTarget)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", target, err))
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to invite %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func postAnnouncement(source string, title string, text string, pinned bool, showToNewMembers bool) error {
+	iSource, err := strconv.ParseUint(source, 10, 64)
+	if err != nil {
+		return err
+	}
+	data := make(map[string]interface{})
+	data["GroupID"] = iSource
+	data["Title"] = title
+	data["Text"] = text
+	if pinned {
+		data["Pinned"] = 1
+	} else {
+		data["Pinned"] = 0
+	}
+	if showToNewMembers {
+		data["Type"] = 20
+	} else {
+		data["Type"] = 10
+	}
+	var response opq.OPQErrorResponse
+	err = webApiCaller("/v1/Group/Announce?qq="+botQQStr, data, &response)
+	if err != nil {
+		return err
+	}
+	if response.Ret != 0 {
+		return response
+	}
+	return nil
+}
+
+type Announcement struct {
+	ID          string `json:"id"`
+	Publisher   string `json:"publisher"`
+	PublishTime int64  `json:"publish_time"`
+	Title       string `json:"title"`
+	Text        string `json:"text"`
+	Pinned      bool   `json:"pinned"`
+}
+
+// OPQ has no API to list announcements, so they are fetched from the web API of QQ groups using the cookies of the account
+var qunWebClient = &http.Client{Timeout: 10 * time.Second}
+
+// getAnnouncementList only gets the latest 20 announcements (and the pinned ones), as the web API does by default
+func getAnnouncementList(source string) ([]Announcement, error) {
+	iSource, err := strconv.ParseUint(source, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	var cookie opq.UserCookieResponse
+	err = luaApiCaller("GetUserCook", make(map[string]interface{}), &cookie)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("bkn", cookie.Gtk)
+	query.Set("qid", fmt.Sprint(iSource))
+	query.Set("ft", "23")
+	query.Set("s", "-1")
+	query.Set("n", "20")
+	query.Set("ni", "1")
+	query.Set("i", "1")
+	req, err := http.NewRequest("POST", "https://web.qun.qq.com/cgi-bin/announce/get_t_list?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Cookie", fmt.Sprintf("%s; p_uin=o%s; p_skey=%s", strings.TrimRight(cookie.Cookies, "; "), botQQStr, cookie.PSkey["qun.qq.com"]))
+	resp, err := qunWebClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var response opq.AnnouncementListResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	if response.ErrorCode != 0 {
+		return nil, fmt.Errorf("[Code: %d] %s", response.ErrorCode, response.ErrorMessage)
+	}
+	r := make([]Announcement, 0, len(response.Pinned)+len(response.Feeds))
+	appendFeeds := func(feeds []opq.AnnouncementInfo, pinned bool) {
+		for _, feed := range feeds {
+			r = append(r, Announcement{
+				ID:          feed.ID,
+				Publisher:   fmt.Sprint(feed.Publisher),
+				PublishTime: feed.PublishTime,
+				Title:       html.UnescapeString(feed.Message.Title),
+				Text:        html.UnescapeString(feed.Message.Text),
+				Pinned:      pinned,
+			})
+		}
+	}
+	appendFeeds(response.Pinned, true)
+	appendFeeds(response.Feeds, false)
+	return r, nil
+}
+
 func getMemberName(source string, target string) (string, error) {
 	vCached, cached := memberNameCache.Get(fmt.Sprintf("%s.%s", source, target))
 	if cached {
@@ -355,20 +882,112 @@ func getPlatformID() (string, error) {
 	return "QQ", nil
 }
 func getGroupList() ([]string, error) {
-	var r []string
-	data := make(map[string]interface{})
-	data["NextToken"] = ""
-	var response opq.GroupListResponse
-	err := luaApiCaller("friendlist.GetTroopListReqV2", data, &response)
+	groups, err := fetchGroupList()
 	if err != nil {
 		return nil, err
 	}
-	for _, group := range response.GroupList {
-		groupNameCache.Set(fmt.Sprint(group.GroupID), group.GroupName, cache.DefaultExpiration)
+	r := make([]string, 0, len(groups))
+	for _, group := range groups {
 		r = append(r, fmt.Sprint(group.GroupID))
 	}
 	return r, nil
 }
+
+// uploadGroupFile uploads a file to the group, content is a local path, base64 content or URL according to dataType
+func uploadGroupFile(source string, name string, dataType string, content string) error {
+	iSource, err := strconv.ParseUint(source, 10, 64)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return errors.New("file name is required")
+	}
+	data := make(map[string]interface{})
+	data["ToUserUid"] = iSource
+	data["SendMsgType"] = "UploadGroupFile"
+	data["FileName"] = name
+	data["Notify"] = true
+	switch dataType {
+	case "file":
+		if len(allowedUploadDirs) == 0 {
+			return errors.New("uploading local files is disabled since no upload directory is allowed")
+		}
+		data["FileBase64"], err = readLocalFile("upload", content, allowedUploadDirs, maxUploadFileSize)
+		if err != nil {
+			return err
+		}
+	case "base64":
+		if size := base64.StdEncoding.DecodedLen(len(content)); size > maxUploadFileSize {
+			return fmt.Errorf("upload file is too large (%d bytes, max %d bytes)", size, maxUploadFileSize)
+		}
+		if _, err := base64.StdEncoding.DecodeString(content); err != nil {
+			return fmt.Errorf("invalid base64 content: %w", err)
+		}
+		data["FileBase64"] = content
+	case "url":
+		// OPQ would read other schemes (e.g. file://) as well, which bypasses allowedUploadDirs
+		u, err := url.Parse(content)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("only http and https URLs can be uploaded")
+		}
+		data["FileUrl"] = content // downloaded by OPQ, the size cannot be checked here
+	default:
+		return fmt.Errorf("unknown data type: %s", dataType)
+	}
+	// OPQ reports nothing until the upload is done, so the start and the end are all that can be notified
+	_ = extEvent.OnGroupFileUpload(source, name, uploadStarted, "")
+	var response opq.OPQErrorResponse
+	err = luaApiCallerWithTimeout("SendMsgV2", uploadTimeout, data, &response)
+	if err == nil && response.Ret != 0 {
+		err = response
+	}
+	if err != nil {
+		_ = extEvent.OnGroupFileUpload(source, name, uploadFailed, err.Error())
+		return err
+	}
+	_ = extEvent.OnGroupFileUpload(source, name, uploadFinished, "")
+	return nil
+}
+
+type Friend struct {
+	ID       string `json:"id"`
+	Nickname string `json:"nickname"`
+	Remark   string `json:"remark"`
+}
+
+func getFriendList() ([]Friend, error) {
+	var r []Friend
+	startIndex := 0
+	for {
+		data := make(map[string]interface{})
+		data["StartIndex"] = startIndex
+		var response opq.FriendListResponse
+		err := luaApiCaller("friendlist.GetFriendListReq", data, &response)
+		if err != nil {
+			return nil, err
+		}
+		for _, friend := range response.FriendList {
+			friendID := fmt.Sprint(friend.FriendUin)
+			r = append(r, Friend{ID: friendID, Nickname: friend.NickName, Remark: friend.Remark})
+		}
+		startIndex += len(response.FriendList)
+		if len(response.FriendList) == 0 || startIndex >= response.TotalFriendCount {
+			break
+		}
+	}
+	// the whole list is known now, so the friends deleted while the events were missed can be dropped
+	friends := make(map[string]bool, len(r))
+	for _, friend := range r {
+		friends[friend.ID] = true
+		userNameCache.Set(friend.ID, friend.Nickname, cache.NoExpiration)
+	}
+	for id := range userNameCache.Items() {
+		if !friends[id] {
+			userNameCache.Delete(id)
+		}
+	}
+	return r, nil
+}
 func getMemberList(id string) ([]string, error) {
 	var r []string
 	var err error
@@ -383,6 +1002,8 @@ func getMemberList(id string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	// roles are not cached while the owner is unknown, or the owner would be cached as an admin
+	owner, ownerErr := getGroupOwner(response.GroupUin)
 	for _, member := range response.MemberList {
 		groupCard := member.GroupCard
 		nickName := member.NickName
@@ -390,6 +1011,15 @@ func getMemberList(id string) ([]string, error) {
 			groupCard = nickName
 		}
 		memberNameCache.Set(fmt.Sprintf("%d.%d", response.GroupUin, member.MemberUin), groupCard, cache.DefaultExpiration)
+		if ownerErr == nil {
+			role := memberRoleMember
+			if member.MemberUin == owner {
+				role = memberRoleOwner
+			} else if member.GroupAdmin == 1 {
+				role = memberRoleAdmin
+			}
+			memberRoleCache.Set(fmt.Sprintf("%d.%d", response.GroupUin, member.MemberUin), role, cache.DefaultExpiration)
+		}
 		r = append(r, fmt.Sprint(member.MemberUin))
 	}
 	return r, nil
@@ -479,12 +1109,31 @@ func convertMessage(opqMsgType string, opqMsg string) (string, error) {
 	return msg, nil
 }
 
+func groupMsgKey(groupID uint64, msgSeq uint64, msgRandom uint64) string {
+	return fmt.Sprintf("%d.%d.%d", groupID, msgSeq, msgRandom)
+}
+
+func getGroupMsgID(groupID uint64, msgSeq uint64, msgRandom uint64) string {
+	vCached, cached := groupMsgIDCache.Get(groupMsgKey(groupID, msgSeq, msgRandom))
+	if cached {
+		return vCached.(string)
+	}
+	return fmt.Sprintf("group%d.0.%d.%d", groupID, msgSeq, msgRandom) // time is unknown
+}
+
 func main() {
 	var err error
 	botAddr = os.Args[3]
 	botQQStr = os.Args[4]
 	botQQ, err = strconv.ParseUint(botQQStr, 10, 64)
 	ubot.AssertNoError(err)
+	allowedImageDirs = splitPathList(os.Getenv("OPQAGENT_IMAGE_DIRS"))
+	allowedUploadDirs = splitPathList(os.Getenv("OPQAGENT_UPLOAD_DIRS"))
+	forwardSelfMessages = os.Getenv("OPQAGENT_FORWARD_SELF_MESSAGES") == "1"
+	if v := os.Getenv("OPQAGENT_MAX_TEXT_LENGTH"); v != "" {
+		maxTextLength, err = strconv.Atoi(v)
+		ubot.AssertNoError(err)
+	}
 	var botConn *gosocketio.Client
 	opqConnected := false
 	opcAcked := false
@@ -519,10 +1168,23 @@ func main() {
 		groupIDStr := fmt.Sprint(data.FromGroupID)
 		groupNameCache.Set(groupIDStr, &data.FromGroupName, cache.DefaultExpiration)
 		memberNameCache.Set(fmt.Sprintf("%d.%d", data.FromGroupID, data.FromUserID), data.FromNickName, cache.DefaultExpiration)
+		msgId := fmt.Sprintf("group%d.%d.%d.%d", data.FromGroupID, data.MsgTime, data.MsgSeq, data.MsgRandom)
+		groupMsgIDCache.Set(groupMsgKey(data.FromGroupID, data.MsgSeq, data.MsgRandom), msgId, cache.DefaultExpiration)
 		if data.FromUserID == botQQ {
+			if resolveEcho(echoChatKey(ubot.GroupMsg, data.FromGroupID), data.MsgType, data.Content, msgId) || !forwardSelfMessages {
+				return
+			}
+			msg, err := convertMessage(data.MsgType, data.Content)
+			if err != nil {
+				return
+			}
+			_ = extEvent.OnReceiveSelfMessage(ubot.GroupMsg,
+				groupIDStr,
+				"",
+				msg,
+				ubot.MsgInfo{ID: msgId})
 			return
 		}
-		msgId := fmt.Sprintf("group%d.%d.%d.%d", data.FromGroupID, data.MsgTime, data.MsgSeq, data.MsgRandom)
 		msg, err := convertMessage(data.MsgType, data.Content)
 		if err != nil {
 			return
@@ -537,6 +1199,19 @@ func main() {
 		var err error
 		data := &e.CurrentPacket.Data
 		if data.FromUin == botQQ {
+			msgId := fmt.Sprintf("friend%d.%d", data.ToUin, data.MsgSeq)
+			if resolveEcho(echoChatKey(ubot.PrivateMsg, data.ToUin), data.MsgType, data.Content, msgId) || !forwardSelfMessages {
+				return
+			}
+			msg, err := convertMessage(data.MsgType, data.Content)
+			if err != nil {
+				return
+			}
+			_ = extEvent.OnReceiveSelfMessage(ubot.PrivateMsg,
+				"",
+				fmt.Sprint(data.ToUin),
+				msg,
+				ubot.MsgInfo{ID: msgId})
 			return
 		}
 		msgId := fmt.Sprintf("friend%d.%d", data.FromUin, data.MsgSeq)
@@ -550,62 +1225,197 @@ func main() {
 			msg,
 			ubot.MsgInfo{ID: msgId})
 	})
-	_ = botConn.On("OnEvents", func(h *gosocketio.Channel, e opq.EventMessagePacket) {
+	events := opq.NewEventRegistry()
+	events.Handle(opq.GroupJoinEventName, func(msg *opq.EventMessage, eventData *opq.GroupJoinEventData) {
+		if eventData.InviteUin == 0 {
+			_ = event.OnMemberJoined(
+				fmt.Sprint(msg.FromUin),
+				fmt.Sprint(eventData.UserID),
+				"")
+		} else {
+			_ = event.OnMemberJoined(
+				fmt.Sprint(msg.FromUin),
+				fmt.Sprint(eventData.UserID),
+				fmt.Sprint(eventData.InviteUin))
+		}
+	})
+	events.Handle(opq.GroupExitEventName, func(msg *opq.EventMessage, eventData *opq.GroupExitEventData) {
+		memberKey := fmt.Sprintf("%d.%d", msg.FromUin, eventData.UserID)
+		memberNameCache.Delete(memberKey)
+		memberRoleCache.Delete(memberKey)
+		if vOperator, removing := removingMemberCache.Get(memberKey); removing {
+			removingMemberCache.Delete(memberKey)
+			_ = extEvent.OnMemberRemoved(
+				fmt.Sprint(msg.FromUin),
+				fmt.Sprint(eventData.UserID),
+				fmt.Sprint(vOperator))
+		} else if removedTipMatcher.MatchString(msg.Content) {
+			_ = extEvent.OnMemberRemoved(
+				fmt.Sprint(msg.FromUin),
+				fmt.Sprint(eventData.UserID),
+				"")
+		} else {
+			_ = event.OnMemberLeft(
+				fmt.Sprint(msg.FromUin),
+				fmt.Sprint(eventData.UserID))
+		}
+	})
+	events.Handle(opq.GroupRevokeEventName, func(msg *opq.EventMessage, eventData *opq.GroupRevokeEventData) {
+		_ = extEvent.OnMessageRecalled(ubot.GroupMsg,
+			fmt.Sprint(eventData.GroupID),
+			fmt.Sprint(eventData.UserID),
+			fmt.Sprint(eventData.AdminUserID),
+			getGroupMsgID(eventData.GroupID, eventData.MsgSeq, eventData.MsgRandom))
+	})
+	events.Handle(opq.GroupAdminEventName, func(msg *opq.EventMessage, eventData *opq.GroupAdminEventData) {
+		role := memberRoleMember
+		if eventData.Flag == 1 {
+			role = memberRoleAdmin
+		}
+		memberRoleCache.Set(fmt.Sprintf("%d.%d", eventData.GroupID, eventData.UserID), role, cache.DefaultExpiration)
+		_ = extEvent.OnMemberRoleChanged(
+			fmt.Sprint(eventData.GroupID),
+			fmt.Sprint(eventData.UserID),
+			role)
+	})
+	events.Handle(opq.GroupShutEventName, func(msg *opq.EventMessage, eventData *opq.GroupShutEventData) {
+		target := "all"
+		if eventData.UserID != 0 {
+			target = fmt.Sprint(eventData.UserID)
+		}
+		_ = extEvent.OnMemberMuted(
+			fmt.Sprint(eventData.GroupID),
+			"", // OPQ does not tell who did it
+			target,
+			eventData.ShutTime)
+	})
+	events.Handle(opq.GroupAdminSysNotifyEventName, func(msg *opq.EventMessage, eventData *opq.GroupAdminSysNotifyEventData) {
+		if eventData.Type != opq.GroupJoinRequestNotifyType && eventData.Type != opq.GroupInvitationNotifyType {
+			return
+		}
+		var result ubot.EventResultType
+		var reason *string
 		var err error
-		data := &e.CurrentPacket.Data
-		switch data.EventName {
-		case opq.GroupJoinEventName:
-			var eventData opq.GroupJoinEventData
-			err = json.Unmarshal(data.EventData, &eventData)
-			if err != nil {
-				return
-			}
-			if eventData.InviteUin == 0 {
-				_ = event.OnMemberJoined(
-					fmt.Sprint(data.EventMessage.FromUin),
-					fmt.Sprint(eventData.UserID),
-					"")
-			} else {
-				_ = event.OnMemberJoined(
-					fmt.Sprint(data.EventMessage.FromUin),
-					fmt.Sprint(eventData.UserID),
-					fmt.Sprint(eventData.InviteUin))
+		if eventData.Type == opq.GroupInvitationNotifyType && eventData.Who == botQQ {
+			result, reason, err = event.ProcessGroupInvitation(
+				fmt.Sprint(eventData.ActionUin),
+				fmt.Sprint(eventData.GroupID),
+				eventData.Content)
+		} else {
+			inviter := ""
+			if eventData.ActionUin != 0 && eventData.ActionUin != eventData.Who {
+				inviter = fmt.Sprint(eventData.ActionUin)
 			}
-		case opq.GroupExitEventName:
-			var eventData opq.GroupExitEventData
-			err = json.Unmarshal(data.EventData, &eventData)
-			if err != nil {
-				return
+			result, reason, err = event.ProcessMembershipRequest(
+				fmt.Sprint(eventData.GroupID),
+				fmt.Sprint(eventData.Who),
+				inviter,
+				eventData.Content)
+		}
+		if err != nil {
+			return
+		}
+		switch result {
+		case ubot.AcceptRequest:
+			eventData.Action = 11
+		case ubot.RejectRequest:
+			eventData.Action = 12
+			if reason != nil {
+				eventData.RefuseContent = *reason
 			}
-			_ = event.OnMemberLeft(
-				fmt.Sprint(data.EventMessage.FromUin),
-				fmt.Sprint(eventData.UserID))
-		case opq.FriendAddedEventName:
-			var eventData opq.FriendAddedEventData
-			err = json.Unmarshal(data.EventData, &eventData)
-			if err != nil {
-				return
+		default:
+			return
+		}
+		_ = luaApiCaller("AnswerInviteGroup", eventData, nil)
+	})
+	events.Handle(opq.FriendAddedEventName, func(msg *opq.EventMessage, eventData *opq.FriendAddedEventData) {
+		result, _, err := event.ProcessFriendRequest(
+			fmt.Sprint(eventData.UserID),
+			fmt.Sprint(eventData.Content))
+		if err != nil {
+			return
+		}
+		switch result {
+		case ubot.AcceptRequest:
+			eventData.Action = 2
+		case ubot.RejectRequest:
+			eventData.Action = 3
+		default:
+			return
+		}
+		_ = luaApiCaller("DealFriend", eventData, nil)
+	})
+	events.Handle(opq.FriendPushAddedEventName, func(msg *opq.EventMessage, eventData *opq.FriendPushAddedEventData) {
+		if u, err := getUserInfo(fmt.Sprint(eventData.UserID)); err == nil {
+			userNameCache.Set(fmt.Sprint(eventData.UserID), u.Nickname, cache.NoExpiration)
+		}
+		_ = extEvent.OnFriendAdded(fmt.Sprint(eventData.UserID))
+	})
+	events.Handle(opq.FriendDeletedEventName, func(msg *opq.EventMessage, eventData *opq.FriendDeletedEventData) {
+		userInfoCache.Delete(fmt.Sprint(eventData.UserID))
+		userNameCache.Delete(fmt.Sprint(eventData.UserID))
+		_ = extEvent.OnFriendDeleted(fmt.Sprint(eventData.UserID))
+	})
+	probeAccount := func() {
+		if probeAccountState() {
+			_ = extEvent.OnAccountState(AccountOnline.String(), accountProbeReason)
+		}
+	}
+	go func() {
+		for range time.Tick(accountProbeInterval) {
+			probeAccount()
+		}
+	}()
+	accountStateHandler := func(state AccountState) func(*opq.EventMessage, *opq.AccountStateEventData) {
+		return func(msg *opq.EventMessage, eventData *opq.AccountStateEventData) {
+			if eventData.Uin != 0 && eventData.Uin != botQQ {
+				return // another account logged in to the same OPQ
 			}
-			var result ubot.EventResultType
-			result, _, err = event.ProcessFriendRequest(
-				fmt.Sprint(eventData.UserID),
-				fmt.Sprint(eventData.Content))
-			if err != nil {
-				return
+			reason := eventData.Content
+			if reason == "" {
+				reason = msg.Content
 			}
-			switch result {
-			case ubot.AcceptRequest:
-				eventData.Action = 2
-			case ubot.RejectRequest:
-				eventData.Action = 3
-			default:
-				return
+			if setAccountState(state, reason) {
+				_ = extEvent.OnAccountState(state.String(), reason)
 			}
-			_ = luaApiCaller("DealFriend", eventData, nil)
+		}
+	}
+	events.Handle(opq.AccountOnlineEventName, accountStateHandler(AccountOnline))
+	events.Handle(opq.AccountOfflineEventName, accountStateHandler(AccountOffline))
+	events.Handle(opq.AccountForceOfflineEventName, accountStateHandler(AccountKickedOffline))
+	events.Handle(opq.AccountNetworkChangeEventName, func(msg *opq.EventMessage, eventData *opq.AccountStateEventData) {
+		if eventData.Uin != 0 && eventData.Uin != botQQ {
+			return
+		}
+		reason := eventData.Content
+		if reason == "" {
+			reason = msg.Content
+		}
+		fmt.Printf("Account network changed: %s\n", reason)
+		_ = extEvent.OnAccountState(getAccountState().String(), reason)
+		go probeAccount()
+	})
+	_ = botConn.On("OnEvents", func(h *gosocketio.Channel, e opq.EventMessagePacket) {
+		if e.CurrentQQ != 0 && e.CurrentQQ != botQQ {
+			return // OPQ pushes the events of all its accounts
+		}
+		err := events.Dispatch(&e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to handle event: %v\n", err)
 		}
 	})
-	err = ubot.HostAccount("QQ"+botQQStr, func(e *ubot.AccountEventEmitter) *ubot.Account {
+	go func() {
+		for {
+			_, err := getFriendList() // populates the user names
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to get the friend list: %v\n", err)
+			}
+			time.Sleep(friendListRefreshInterval)
+		}
+	}()
+	err = hostAccount("QQ"+botQQStr, func(e *ubot.AccountEventEmitter, extE *ExtAccountEventEmitter) (*ubot.Account, *ExtAccount) {
 		event = e
+		extEvent = extE
 		return &ubot.Account{
 			GetGroupName:    getGroupName,
 			GetUserName:     getUserName,
@@ -619,6 +1429,18 @@ func main() {
 			GetPlatformID:   getPlatformID,
 			GetGroupList:    getGroupList,
 			GetMemberList:   getMemberList,
+		}, &ExtAccount{
+			SendChatMessageEx:   sendChatMessageEx,
+			RecallMessage:       recallMessage,
+			SetMemberCard:       setMemberCard,
+			SetMemberAdmin:      setMemberAdmin,
+			SetMemberTitle:      setMemberTitle,
+			LeaveGroup:          leaveGroup,
+			PostAnnouncement:    postAnnouncement,
+			GetAnnouncementList: getAnnouncementList,
+			GetFriendList:       getFriendList,
+			UploadGroupFile:     uploadGroupFile,
+			InviteMembers:       inviteMembers,
 		}
 	})
 	ubot.AssertNoError(err)
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/patrickmn/go-cache"
+)
+
+func TestCheckManagePermission(t *testing.T) {
+	const groupID, admin, member uint64 = 1, 3, 4
+	oldBotQQ := botQQ
+	botQQ = 2
+	defer func() {
+		botQQ = oldBotQQ
+		forgetGroup(groupID)
+	}()
+	setRoles := func(owner uint64, roles map[uint64]string) {
+		forgetGroup(groupID)
+		groupOwnerCache.Set(fmt.Sprint(groupID), owner, cache.DefaultExpiration)
+		for uin, role := range roles {
+			memberRoleCache.Set(fmt.Sprintf("%d.%d", groupID, uin), role, cache.DefaultExpiration)
+		}
+	}
+	cases := []struct {
+		name     string
+		owner    uint64
+		roles    map[uint64]string
+		target   uint64
+		required string // empty if permitted
+	}{
+		{"owner", botQQ, map[uint64]string{botQQ: memberRoleOwner, admin: memberRoleAdmin}, admin, ""},
+		{"owner cached as admin", botQQ, map[uint64]string{botQQ: memberRoleAdmin, admin: memberRoleAdmin}, admin, ""},
+		{"owner cached as member", botQQ, map[uint64]string{botQQ: memberRoleMember}, 0, ""},
+		{"admin on member", 5, map[uint64]string{botQQ: memberRoleAdmin, member: memberRoleMember}, member, ""},
+		{"admin on admin", 5, map[uint64]string{botQQ: memberRoleAdmin, admin: memberRoleAdmin}, admin, memberRoleOwner},
+		{"member", 5, map[uint64]string{botQQ: memberRoleMember}, member, memberRoleAdmin},
+		{"unknown role", 5, nil, member, ""},
+	}
+	for _, c := range cases {
+		setRoles(c.owner, c.roles)
+		err := checkManagePermission("remove_member", groupID, c.target)
+		var permissionErr PermissionError
+		switch {
+		case c.required == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		case c.required != "" && !errors.As(err, &permissionErr):
+			t.Errorf("%s: got %v, want a PermissionError", c.name, err)
+		case c.required != "" && permissionErr.RequiredRole != c.required:
+			t.Errorf("%s: required role %s, want %s", c.name, permissionErr.RequiredRole, c.required)
+		}
+	}
+}
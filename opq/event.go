@@ -0,0 +1,81 @@
+package opq
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// eventDataTypes maps every known event name to the type of its EventData.
+// Adding an event only takes an entry here (and the data struct).
+var eventDataTypes = map[string]reflect.Type{
+	GroupJoinEventName:            reflect.TypeOf(GroupJoinEventData{}),
+	GroupExitEventName:            reflect.TypeOf(GroupExitEventData{}),
+	GroupRevokeEventName:          reflect.TypeOf(GroupRevokeEventData{}),
+	GroupAdminEventName:           reflect.TypeOf(GroupAdminEventData{}),
+	GroupShutEventName:            reflect.TypeOf(GroupShutEventData{}),
+	GroupAdminSysNotifyEventName:  reflect.TypeOf(GroupAdminSysNotifyEventData{}),
+	FriendAddedEventName:          reflect.TypeOf(FriendAddedEventData{}),
+	FriendDeletedEventName:        reflect.TypeOf(FriendDeletedEventData{}),
+	FriendPushAddedEventName:      reflect.TypeOf(FriendPushAddedEventData{}),
+	AccountOnlineEventName:        reflect.TypeOf(AccountStateEventData{}),
+	AccountOfflineEventName:       reflect.TypeOf(AccountStateEventData{}),
+	AccountForceOfflineEventName:  reflect.TypeOf(AccountStateEventData{}),
+	AccountNetworkChangeEventName: reflect.TypeOf(AccountStateEventData{}),
+}
+
+// DecodeEventData decodes the EventData of the named event, returning a pointer to its typed struct
+func DecodeEventData(eventName string, eventData json.RawMessage) (interface{}, error) {
+	dataType, ok := eventDataTypes[eventName]
+	if !ok {
+		return nil, fmt.Errorf("unknown event: %s", eventName)
+	}
+	data := reflect.New(dataType)
+	err := json.Unmarshal(eventData, data.Interface())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", eventName, err)
+	}
+	return data.Interface(), nil
+}
+
+// EventRegistry dispatches events to the handlers registered by name
+type EventRegistry struct {
+	handlers map[string]reflect.Value
+}
+
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{handlers: make(map[string]reflect.Value)}
+}
+
+// Handle registers the handler of the named event.
+// handler must be a func(*EventMessage, *T), where T is the data type of the event.
+func (r *EventRegistry) Handle(eventName string, handler interface{}) {
+	dataType, ok := eventDataTypes[eventName]
+	if !ok {
+		panic(fmt.Errorf("unknown event: %s", eventName))
+	}
+	fobj := reflect.ValueOf(handler)
+	fType := fobj.Type()
+	if fType.Kind() != reflect.Func ||
+		fType.NumIn() != 2 || fType.NumOut() != 0 ||
+		fType.In(0) != reflect.TypeOf((*EventMessage)(nil)) ||
+		fType.In(1) != reflect.PtrTo(dataType) {
+		panic(fmt.Errorf("the handler of %s must be a func(*EventMessage, *%s)", eventName, dataType.Name()))
+	}
+	r.handlers[eventName] = fobj
+}
+
+// Dispatch calls the handler of the event, if any. Events without a handler are ignored.
+func (r *EventRegistry) Dispatch(e *EventMessagePacket) error {
+	data := &e.CurrentPacket.Data
+	handler, ok := r.handlers[data.EventName]
+	if !ok {
+		return nil
+	}
+	eventData, err := DecodeEventData(data.EventName, data.EventData)
+	if err != nil {
+		return err
+	}
+	handler.Call([]reflect.Value{reflect.ValueOf(&data.EventMessage), reflect.ValueOf(eventData)})
+	return nil
+}
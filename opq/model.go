@@ -91,6 +91,22 @@ type UserInfo struct {
 	Uin           uint64 `json:"uin,omitempty"`
 }
 
+type FriendListResponse struct {
+	FriendList       []FriendInfo `json:"Friendlist,omitempty"`
+	GetFriendCount   int          `json:"GetfriendCount,omitempty"`
+	StartIndex       int          `json:"StartIndex,omitempty"`
+	TotalFriendCount int          `json:"Totoal_friend_count,omitempty"` //Note Totoal shoule be a mistaken spelling, but we must keep it unchanged
+}
+
+type FriendInfo struct {
+	FriendUin uint64 `json:"FriendUin,omitempty"`
+	IsRemark  bool   `json:"IsRemark,omitempty"`
+	NickName  string `json:"NickName,omitempty"`
+	OnlineStr string `json:"OnlineStr,omitempty"`
+	Remark    string `json:"Remark,omitempty"`
+	Status    int    `json:"Status,omitempty"`
+}
+
 type GroupListResponse struct {
 	Count     int         `json:"Count,omitempty"`
 	NextToken string      `json:"NextToken,omitempty"`
@@ -130,6 +146,33 @@ type MemberInfo struct {
 	Status        int    `json:"Status,omitempty"`
 }
 
+type UserCookieResponse struct {
+	ClientKey string            `json:"ClientKey,omitempty"`
+	Cookies   string            `json:"Cookies,omitempty"`
+	Gtk       string            `json:"Gtk,omitempty"`
+	Gtk32     string            `json:"Gtk32,omitempty"`
+	PSkey     map[string]string `json:"PSkey,omitempty"`
+	Skey      string            `json:"Skey,omitempty"`
+}
+
+// AnnouncementListResponse is returned by the web API of QQ groups rather than OPQ
+type AnnouncementListResponse struct {
+	ErrorCode    int                `json:"ec"`
+	ErrorMessage string             `json:"em,omitempty"`
+	Feeds        []AnnouncementInfo `json:"feeds,omitempty"`
+	Pinned       []AnnouncementInfo `json:"inst,omitempty"`
+}
+
+type AnnouncementInfo struct {
+	ID          string `json:"fid,omitempty"`
+	Publisher   uint64 `json:"u,omitempty"`
+	PublishTime int64  `json:"pubt,omitempty"`
+	Message     struct {
+		Title string `json:"title,omitempty"`
+		Text  string `json:"text,omitempty"`
+	} `json:"msg,omitempty"`
+}
+
 type OPQErrorResponse struct {
 	Ret int    `json:"Ret,omitempty"`
 	Msg string `json:"Msg,omitempty"`
@@ -172,6 +215,59 @@ type GroupJoinEventData struct {
 	UserName  string `json:"UserName,omitempty"`
 }
 
+const GroupRevokeEventName = "ON_EVENT_GROUP_REVOKE"
+
+type GroupRevokeEventData struct {
+	AdminUserID uint64 `json:"AdminUserID,omitempty"`
+	GroupID     uint64 `json:"GroupID,omitempty"`
+	MsgRandom   uint64 `json:"MsgRandom,omitempty"`
+	MsgSeq      uint64 `json:"MsgSeq,omitempty"`
+	UserID      uint64 `json:"UserID,omitempty"`
+}
+
+const GroupAdminEventName = "ON_EVENT_GROUP_ADMIN"
+
+type GroupAdminEventData struct {
+	Extra   string `json:"Extra,omitempty"`
+	Flag    int    `json:"Flag,omitempty"` // 1 for promoted, 0 for demoted
+	GroupID uint64 `json:"GroupID,omitempty"`
+	UserID  uint64 `json:"UserID,omitempty"`
+}
+
+const GroupShutEventName = "ON_EVENT_GROUP_SHUT"
+
+type GroupShutEventData struct {
+	GroupID  uint64 `json:"GroupID,omitempty"`
+	ShutTime int    `json:"ShutTime,omitempty"` // in seconds, 0 for unmuted
+	UserID   uint64 `json:"UserID,omitempty"`   // 0 for the whole group
+}
+
+const GroupAdminSysNotifyEventName = "ON_EVENT_GROUP_ADMINSYSNOTIFY"
+
+const (
+	GroupJoinRequestNotifyType = 1 // Who applies to join the group
+	GroupInvitationNotifyType  = 2 // ActionUin invites Who (which may be the bot itself) into the group
+)
+
+type GroupAdminSysNotifyEventData struct {
+	Seq             uint64 `json:"Seq,omitempty"`
+	Type            int    `json:"Type,omitempty"`
+	MsgTypeStr      string `json:"MsgTypeStr,omitempty"`
+	Who             uint64 `json:"Who,omitempty"`
+	WhoName         string `json:"WhoName,omitempty"`
+	MsgStatusStr    string `json:"MsgStatusStr,omitempty"`
+	Content         string `json:"Content,omitempty"`
+	RefuseContent   string `json:"RefuseContent,omitempty"`
+	Flag7           int64  `json:"Flag_7,omitempty"`
+	Flag8           int64  `json:"Flag_8,omitempty"`
+	GroupID         uint64 `json:"GroupId,omitempty"`
+	GroupName       string `json:"GroupName,omitempty"`
+	ActionUin       uint64 `json:"ActionUin,omitempty"`
+	ActionName      string `json:"ActionName,omitempty"`
+	ActionGroupCard string `json:"ActionGroupCard,omitempty"`
+	Action          int    `json:"Action,omitempty"` // set to 11 to accept, 12 to reject when answering
+}
+
 const FriendAddedEventName = "ON_EVENT_FRIEND_ADDED"
 
 type FriendAddedEventData struct {
@@ -183,3 +279,28 @@ type FriendAddedEventData struct {
 	FromGroupName string `json:"FromGroupName,omitempty"`
 	Action        int    `json:"Action,omitempty"`
 }
+
+const FriendDeletedEventName = "ON_EVENT_FRIEND_DELETE"
+
+type FriendDeletedEventData struct {
+	UserID uint64 `json:"UserID,omitempty"`
+}
+
+const FriendPushAddedEventName = "ON_EVENT_NOTIFY_PUSHADDFRD"
+
+type FriendPushAddedEventData struct {
+	UserID uint64 `json:"UserID,omitempty"`
+}
+
+const AccountOnlineEventName = "ON_EVENT_QQ_ONLINE"
+
+const AccountOfflineEventName = "ON_EVENT_QQ_OFFLINE"
+
+const AccountForceOfflineEventName = "ON_EVENT_QQ_FORCE_OFFLINE"
+
+const AccountNetworkChangeEventName = "ON_EVENT_QQ_NETWORK_CHANGE"
+
+type AccountStateEventData struct {
+	Content string `json:"Content,omitempty"`
+	Uin     uint64 `json:"Uin,omitempty"`
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	ubot "github.com/UBotPlatform/UBot.Common.Go"
+)
+
+// MsgPacketKind tells which OPQ message type a packet is sent as.
+type MsgPacketKind int
+
+const (
+	// TextPacket is plain text, which may contain at/face markup.
+	TextPacket MsgPacketKind = iota
+	// PicturePacket is text with exactly one picture, placed before the text,
+	// or after it if Content starts with [PICFLAG].
+	// The picture comes from PicUrl, PicBase64 or PicPath (a local file read right before sending).
+	PicturePacket
+	// ForwardPacket resends an existing message (e.g. big faces) by ForwardField and ForwardBuf.
+	ForwardPacket
+)
+
+const picFlag = "[PICFLAG]"
+
+// MsgPacket is one message sent to OPQ. A UBot message is planned into one or more packets.
+type MsgPacket struct {
+	Kind         MsgPacketKind
+	Content      string
+	PicUrl       string
+	PicBase64    string
+	PicPath      string
+	ForwardField int
+	ForwardBuf   string
+}
+
+func (p *MsgPacket) IsEmpty() bool {
+	return p.Kind == TextPacket && p.Content == ""
+}
+
+var opqMarkupMatcher = regexp.MustCompile(`\[(?:ATALL\(\)|ATUSER\(\d+\)|PICFLAG|表情\d+)\]`)
+
+// OPQ has no escaping mechanism either, so user text is neutralized
+// by putting a zero-width space right after the bracket
+var opqMarkupPrefixMatcher = regexp.MustCompile(`(?i)\[(ATALL|ATUSER|PICFLAG|表情)`)
+
+func neutralizeMarkup(text string) string {
+	return opqMarkupPrefixMatcher.ReplaceAllString(text, "[\u200b$1")
+}
+
+// splitContent cuts content into pieces of at most maxLength characters,
+// preferring line boundaries and never breaking an OPQ markup token
+func splitContent(content string, maxLength int) []string {
+	if maxLength <= 0 || utf8.RuneCountInString(content) <= maxLength {
+		return []string{content}
+	}
+	tokens := opqMarkupMatcher.FindAllStringIndex(content, -1)
+	var r []string
+	// pieces left blank by the cut (e.g. between paragraphs) are dropped
+	appendPiece := func(piece string) {
+		piece = strings.TrimSuffix(strings.TrimSuffix(piece, "\n"), "\r")
+		if strings.TrimSpace(piece) != "" {
+			r = append(r, piece)
+		}
+	}
+	start, length, lineBreak := 0, 0, -1
+	for i, t := 0, 0; i < len(content); {
+		var width, count int
+		if t < len(tokens) && tokens[t][0] == i {
+			width = tokens[t][1] - i
+			count = utf8.RuneCountInString(content[i : i+width])
+			t++
+		} else {
+			_, width = utf8.DecodeRuneInString(content[i:])
+			count = 1
+		}
+		if length+count > maxLength && i > start {
+			cut := i
+			if lineBreak > start {
+				cut = lineBreak
+			}
+			appendPiece(content[start:cut])
+			start, length, lineBreak = cut, utf8.RuneCountInString(content[cut:i]), -1
+		}
+		length += count
+		if content[i] == '\n' {
+			lineBreak = i + 1
+		}
+		i += width
+	}
+	appendPiece(content[start:])
+	return r
+}
+
+// planMsgPackets converts UBot message entities into the packets to be sent, keeping their order.
+// It does no I/O, so that adding an entity type only takes a case here and, for a new kind, one in the send loop.
+func planMsgPackets(entities []ubot.MsgEntity, maxTextLength int) ([]*MsgPacket, error) {
+	packets := make([]*MsgPacket, 0, 2)
+	packet := &MsgPacket{}
+	picAfterText := false
+	flushPacket := func() {
+		if packet.IsEmpty() {
+			return
+		}
+		pieces := splitContent(packet.Content, maxTextLength)
+		if len(pieces) == 0 && packet.Kind == PicturePacket {
+			pieces = []string{""}
+		}
+		// when the text is split, the picture stays with the piece next to it
+		picPiece := 0
+		if picAfterText {
+			picPiece = len(pieces) - 1
+		}
+		for i, content := range pieces {
+			piece := &MsgPacket{Content: content}
+			if i == picPiece && packet.Kind == PicturePacket {
+				piece.Kind = PicturePacket
+				piece.PicUrl = packet.PicUrl
+				piece.PicBase64 = packet.PicBase64
+				piece.PicPath = packet.PicPath
+				if picAfterText {
+					piece.Content = picFlag + content
+				}
+			}
+			packets = append(packets, piece)
+		}
+		packet = &MsgPacket{}
+		picAfterText = false
+	}
+	standalonePacket := func(p *MsgPacket) {
+		flushPacket()
+		packets = append(packets, p)
+	}
+	// OPQ carries at most one picture per message, which is placed before the text,
+	// or after the text if the content starts with [PICFLAG].
+	// Several pictures can only be sent together by PicMd5s, which needs them to be on the QQ servers already,
+	// so every picture here (from a URL, base64 or a file) starts a new message.
+	imagePacket := func(setter func()) {
+		if packet.Kind == PicturePacket {
+			flushPacket()
+		}
+		packet.Kind = PicturePacket
+		setter()
+		if packet.Content != "" { //文字在图片之前，此消息不能再追加文字
+			picAfterText = true
+			flushPacket()
+		}
+	}
+	for _, entity := range entities {
+		switch entity.Type {
+		case "text":
+			packet.Content += neutralizeMarkup(entity.Data)
+		case "face":
+			faceID, err := strconv.ParseUint(entity.Data, 10, 32)
+			if err != nil {
+				return nil, errors.New("invalid face entity")
+			}
+			packet.Content += fmt.Sprintf("[表情%d]", faceID)
+		case "at":
+			if entity.Data == "all" {
+				packet.Content += "[ATALL()]"
+			} else {
+				userID, err := strconv.ParseUint(entity.Data, 10, 64)
+				if err != nil {
+					return nil, errors.New("invalid at entity")
+				}
+				packet.Content += fmt.Sprintf("[ATUSER(%d)]", userID)
+			}
+		case "image_online":
+			imagePacket(func() {
+				packet.PicUrl = entity.Data
+			})
+		case "image_base64":
+			imagePacket(func() {
+				packet.PicBase64 = entity.Data
+			})
+		case "image_file":
+			imagePacket(func() {
+				packet.PicPath = entity.Data
+			})
+		case "big_face":
+			pComma := strings.IndexByte(entity.Data, ',')
+			if pComma == -1 {
+				return nil, errors.New("invalid big_face entity")
+			}
+			forwardField, err := strconv.Atoi(entity.Data[:pComma])
+			if err != nil {
+				return nil, errors.New("invalid big_face entity")
+			}
+			forwardBuf := entity.Data[pComma+1:]
+			standalonePacket(&MsgPacket{Kind: ForwardPacket, ForwardField: forwardField, ForwardBuf: forwardBuf})
+		}
+	}
+	flushPacket()
+	return packets, nil
+}
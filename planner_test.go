@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	ubot "github.com/UBotPlatform/UBot.Common.Go"
+)
+
+func TestSplitContent(t *testing.T) {
+	cases := []struct {
+		name      string
+		content   string
+		maxLength int
+		want      []string
+	}{
+		{"short", "abc", 3, []string{"abc"}},
+		{"no limit", "abcdef", 0, []string{"abcdef"}},
+		{"by length", "abcdefg", 3, []string{"abc", "def", "g"}},
+		{"at line break", "ab\ncdef", 4, []string{"ab", "cdef"}},
+		{"crlf line break", "ab\r\ncdef", 4, []string{"ab", "cdef"}},
+		{"runes", "一二三四五", 2, []string{"一二", "三四", "五"}},
+		{"around at", "ab[ATUSER(12345)]cd", 3, []string{"ab", "[ATUSER(12345)]", "cd"}},
+		{"around face", "a[表情12]b", 2, []string{"a", "[表情12]", "b"}},
+		{"blank between paragraphs", "abc\n\ndef", 3, []string{"abc", "def"}},
+		{"leading blank lines", "\n\n\n\nabcdef", 3, []string{"abc", "def"}},
+		{"blank spaces", "abc\n   \ndef", 3, []string{"abc", "def"}},
+		{"trailing blank lines", "abcdef\n\n\n\n", 3, []string{"abc", "def"}},
+	}
+	for _, c := range cases {
+		got := splitContent(c.content, c.maxLength)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: splitContent(%q, %d) = %q, want %q", c.name, c.content, c.maxLength, got, c.want)
+		}
+	}
+}
+
+func TestPlanMsgPackets(t *testing.T) {
+	cases := []struct {
+		name          string
+		entities      []ubot.MsgEntity
+		maxTextLength int
+		want          []*MsgPacket
+	}{
+		{
+			name:     "text",
+			entities: []ubot.MsgEntity{{Type: "text", Data: "hello"}},
+			want:     []*MsgPacket{{Content: "hello"}},
+		},
+		{
+			name:     "text markup neutralized",
+			entities: []ubot.MsgEntity{{Type: "text", Data: "[ATALL()]"}},
+			want:     []*MsgPacket{{Content: "[\u200bATALL()]"}},
+		},
+		{
+			name: "face and at",
+			entities: []ubot.MsgEntity{
+				{Type: "at", Data: "12345"},
+				{Type: "face", Data: "12"},
+				{Type: "at", Data: "all"},
+			},
+			want: []*MsgPacket{{Content: "[ATUSER(12345)][表情12][ATALL()]"}},
+		},
+		{
+			name: "image then text",
+			entities: []ubot.MsgEntity{
+				{Type: "image_online", Data: "http://example.com/a.png"},
+				{Type: "text", Data: "after"},
+			},
+			want: []*MsgPacket{{Kind: PicturePacket, Content: "after", PicUrl: "http://example.com/a.png"}},
+		},
+		{
+			name: "text then image",
+			entities: []ubot.MsgEntity{
+				{Type: "text", Data: "caption"},
+				{Type: "image_base64", Data: "AAAA"},
+			},
+			want: []*MsgPacket{{Kind: PicturePacket, Content: "[PICFLAG]caption", PicBase64: "AAAA"}},
+		},
+		{
+			name: "text then image then text",
+			entities: []ubot.MsgEntity{
+				{Type: "text", Data: "before"},
+				{Type: "image_file", Data: "/tmp/a.png"},
+				{Type: "text", Data: "after"},
+			},
+			want: []*MsgPacket{
+				{Kind: PicturePacket, Content: "[PICFLAG]before", PicPath: "/tmp/a.png"},
+				{Content: "after"},
+			},
+		},
+		{
+			name: "several images",
+			entities: []ubot.MsgEntity{
+				{Type: "image_online", Data: "http://example.com/a.png"},
+				{Type: "image_online", Data: "http://example.com/b.png"},
+				{Type: "text", Data: "b"},
+				{Type: "image_base64", Data: "AAAA"},
+			},
+			want: []*MsgPacket{
+				{Kind: PicturePacket, PicUrl: "http://example.com/a.png"},
+				{Kind: PicturePacket, Content: "b", PicUrl: "http://example.com/b.png"},
+				{Kind: PicturePacket, PicBase64: "AAAA"},
+			},
+		},
+		{
+			name: "caption and several images",
+			entities: []ubot.MsgEntity{
+				{Type: "text", Data: "caption"},
+				{Type: "image_online", Data: "http://example.com/a.png"},
+				{Type: "image_online", Data: "http://example.com/b.png"},
+				{Type: "image_online", Data: "http://example.com/c.png"},
+			},
+			want: []*MsgPacket{
+				{Kind: PicturePacket, Content: "[PICFLAG]caption", PicUrl: "http://example.com/a.png"},
+				{Kind: PicturePacket, PicUrl: "http://example.com/b.png"},
+				{Kind: PicturePacket, PicUrl: "http://example.com/c.png"},
+			},
+		},
+		{
+			name: "big face",
+			entities: []ubot.MsgEntity{
+				{Type: "text", Data: "a"},
+				{Type: "big_face", Data: "12,buf"},
+				{Type: "text", Data: "b"},
+			},
+			want: []*MsgPacket{
+				{Content: "a"},
+				{Kind: ForwardPacket, ForwardField: 12, ForwardBuf: "buf"},
+				{Content: "b"},
+			},
+		},
+		{
+			name:          "split text",
+			entities:      []ubot.MsgEntity{{Type: "text", Data: "abc\ndef"}},
+			maxTextLength: 4,
+			want:          []*MsgPacket{{Content: "abc"}, {Content: "def"}},
+		},
+		{
+			name: "split image then text",
+			entities: []ubot.MsgEntity{
+				{Type: "image_online", Data: "http://example.com/a.png"},
+				{Type: "text", Data: "abc\ndef"},
+			},
+			maxTextLength: 4,
+			want: []*MsgPacket{
+				{Kind: PicturePacket, Content: "abc", PicUrl: "http://example.com/a.png"},
+				{Content: "def"},
+			},
+		},
+		{
+			name: "split text then image",
+			entities: []ubot.MsgEntity{
+				{Type: "text", Data: "abc\ndef"},
+				{Type: "image_online", Data: "http://example.com/a.png"},
+			},
+			maxTextLength: 4,
+			want: []*MsgPacket{
+				{Content: "abc"},
+				{Kind: PicturePacket, Content: "[PICFLAG]def", PicUrl: "http://example.com/a.png"},
+			},
+		},
+		{
+			name: "image with blank text",
+			entities: []ubot.MsgEntity{
+				{Type: "image_online", Data: "http://example.com/a.png"},
+				{Type: "text", Data: "\n\n\n\n\n"},
+			},
+			maxTextLength: 3,
+			want:          []*MsgPacket{{Kind: PicturePacket, PicUrl: "http://example.com/a.png"}},
+		},
+		{
+			name:     "unknown entity",
+			entities: []ubot.MsgEntity{{Type: "text", Data: "a"}, {Type: "unknown", Data: "x"}},
+			want:     []*MsgPacket{{Content: "a"}},
+		},
+	}
+	for _, c := range cases {
+		got, err := planMsgPackets(c.entities, c.maxTextLength)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %s, want %s", c.name, describePackets(got), describePackets(c.want))
+		}
+	}
+}
+
+func TestPlanMsgPacketsInvalid(t *testing.T) {
+	cases := []struct {
+		name   string
+		entity ubot.MsgEntity
+	}{
+		{"face", ubot.MsgEntity{Type: "face", Data: "smile"}},
+		{"at", ubot.MsgEntity{Type: "at", Data: "someone"}},
+		{"big_face without comma", ubot.MsgEntity{Type: "big_face", Data: "buf"}},
+		{"big_face with bad field", ubot.MsgEntity{Type: "big_face", Data: "x,buf"}},
+	}
+	for _, c := range cases {
+		_, err := planMsgPackets([]ubot.MsgEntity{c.entity}, 0)
+		if err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
+
+func describePackets(packets []*MsgPacket) string {
+	r := make([]string, 0, len(packets))
+	for _, p := range packets {
+		r = append(r, fmt.Sprintf("%+v", *p))
+	}
+	return strings.Join(r, " ")
+}